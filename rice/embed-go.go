@@ -3,13 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"go/build"
 	"go/format"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -21,7 +27,104 @@ const boxFilename = "rice-box.go"
 
 const lowerhex = "0123456789abcdef"
 
-func writeBoxesGo(pkg *build.Package, out io.Writer) error {
+var excludeFlag = flag.String("exclude", "", "comma-separated globs (doublestar syntax) of paths to exclude from the box, relative to the box root")
+var includeFlag = flag.String("include", "", "comma-separated globs (doublestar syntax); when set, only matching paths are embedded")
+
+// riceCacheFilename is written next to rice-box.go so subsequent runs can
+// tell which files changed without re-reading and re-hashing everything.
+const riceCacheFilename = ".rice-cache.json"
+
+type cacheEntry struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"mtime"`
+	SHA256     string `json:"sha256"`
+	Identifier string `json:"identifier"`
+	// Escaped is the already-escaped Go string body produced for this file
+	// by a previous run, so an unchanged file can be spliced straight into
+	// the generated source instead of being reopened and re-escaped byte
+	// by byte on every run.
+	Escaped string `json:"escaped"`
+}
+
+// identifierFor derives a stable identifier from a file's path within the
+// box, rather than a monotonically increasing counter, so that a file or
+// directory keeps the same identifier from one run to the next and diffs
+// stay small when only a handful of files actually changed.
+func identifierFor(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func loadCacheManifest(pkgDir string) map[string]cacheEntry {
+	manifest := make(map[string]cacheEntry)
+
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, riceCacheFilename))
+	if err != nil {
+		return manifest
+	}
+
+	var entries []cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		verbosef("warning: ignoring unreadable %s: %s\n", riceCacheFilename, err)
+		return manifest
+	}
+
+	for _, entry := range entries {
+		manifest[entry.Path] = entry
+	}
+	return manifest
+}
+
+func saveCacheManifest(pkgDir string, manifest map[string]cacheEntry) error {
+	entries := make([]cacheEntry, 0, len(manifest))
+	for _, entry := range manifest {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(pkgDir, riceCacheFilename), data, 0644)
+}
+
+// effectivePatterns returns the raw pattern strings an ignoreSet was built
+// from, so they can be recorded in the box metadata and consumers (like
+// `rice append`) can reproduce the same filtering.
+func effectivePatterns(s *ignoreSet) []string {
+	patterns := make([]string, 0, len(s.rules))
+	for _, rule := range s.rules {
+		patterns = append(patterns, rule.raw)
+	}
+	return patterns
+}
+
+// shouldExclude decides whether the walk should drop filename (relative to
+// the box root, "" for the root itself): either it matches an exclude/
+// .riceignore rule, or an -include filter is set and it doesn't match.
+// skipDir reports whether the whole subtree can be pruned via
+// filepath.SkipDir. Exclude rules prune directories outright, since
+// excluding a directory excludes everything beneath it. The include filter
+// never does: a directory's own name not matching the include glob says
+// nothing about whether files further down do (e.g. -include="*.go" must
+// still reach sub/a.go even though "sub" itself doesn't match), so it is
+// only ever applied to files.
+func shouldExclude(ignores, includes *ignoreSet, include, filename string, isDir bool) (exclude, skipDir bool) {
+	if filename == "" {
+		return false, false
+	}
+	if ignores.matches(filename, isDir) {
+		return true, isDir
+	}
+	if !isDir && include != "" && !includes.matches(filename, false) {
+		return true, false
+	}
+	return false, false
+}
+
+func writeBoxesGo(pkg *build.Package, out io.Writer, exclude, include string) error {
 	boxMap := findBoxes(pkg)
 
 	// notify user when no calls to rice.FindBox are made (is this an error and therefore os.Exit(1) ?
@@ -34,6 +137,14 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 
 	var boxes []*boxDataType
 
+	oldManifest := loadCacheManifest(pkg.Dir)
+	newManifest := make(map[string]cacheEntry)
+
+	// pathToCacheKey collects the on-disk files whose content still needs to
+	// be read and escaped by the fasttemplate pass below, so the escaped
+	// output can be written back into newManifest once it's known.
+	pathToCacheKey := make(map[string]string)
+
 	for boxname := range boxMap {
 		// find path and filename for this box
 		boxPath := filepath.Join(pkg.Dir, boxname)
@@ -56,12 +167,26 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 			return fmt.Errorf("Error: unable to access box at %s\n", boxPath)
 		}
 
+		ignores := newIgnoreSet()
+		if err := ignores.addPatterns(exclude); err != nil {
+			return fmt.Errorf("Error: invalid -exclude pattern: %s\n", err)
+		}
+		includes := newIgnoreSet()
+		if err := includes.addPatterns(include); err != nil {
+			return fmt.Errorf("Error: invalid -include pattern: %s\n", err)
+		}
+		if err := ignores.loadRiceignore(boxPath); err != nil {
+			return fmt.Errorf("Error: reading %s for box %s: %s\n", riceignoreFilename, boxname, err)
+		}
+
 		// create box datastructure (used by template)
 		box := &boxDataType{
-			BoxName: boxname,
-			UnixNow: boxInfo.ModTime().Unix(),
-			Files:   make([]*fileDataType, 0),
-			Dirs:    make(map[string]*dirDataType),
+			BoxName:         boxname,
+			UnixNow:         boxInfo.ModTime().Unix(),
+			IgnorePatterns:  effectivePatterns(ignores),
+			IncludePatterns: effectivePatterns(includes),
+			Files:           make([]*fileDataType, 0),
+			Dirs:            make(map[string]*dirDataType),
 		}
 
 		if !boxInfo.IsDir() {
@@ -78,9 +203,18 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 			filename := strings.TrimPrefix(path, boxPath)
 			filename = strings.Replace(filename, "\\", "/", -1)
 			filename = strings.TrimPrefix(filename, "/")
+
+			if exclude, skipDir := shouldExclude(ignores, includes, include, filename, info.IsDir()); exclude {
+				verbosef("\tskipping %s (excluded by filter)\n", filename)
+				if skipDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
 			if info.IsDir() {
 				dirData := &dirDataType{
-					Identifier: "dir" + nextIdentifier(),
+					Identifier: "dir" + identifierFor(filename),
 					FileName:   filename,
 					ModTime:    info.ModTime().Unix(),
 					ChildFiles: make([]*fileDataType, 0),
@@ -97,18 +231,52 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 				}
 			} else {
 				fileData := &fileDataType{
-					Identifier: "file" + nextIdentifier(),
+					Identifier: "file" + identifierFor(filename),
 					FileName:   filename,
 					ModTime:    info.ModTime().Unix(),
 				}
 				verbosef("\tincludes file: '%s'\n", fileData.FileName)
-				/*
-					fileData.Content, err = ioutil.ReadFile(path)
-					if err != nil {
-						return fmt.Errorf("error reading file content while walking box: %s\n", err)
+
+				cacheKey := boxname + "/" + filename
+				var cachedEscaped string
+				cacheHit := false
+
+				if cached, ok := oldManifest[cacheKey]; ok &&
+					cached.Size == info.Size() && cached.ModTime == info.ModTime().Unix() {
+					// unchanged since the last run; skip the read+hash entirely,
+					// and reuse the escaped content already computed for it so
+					// the escape loop below doesn't reopen and rewalk its bytes
+					verbosef("\tfile '%s' unchanged since last run, using cached digest\n", filename)
+					fileData.Digest = cached.SHA256
+					fileData.OriginalSize = cached.Size
+					cachedEscaped = cached.Escaped
+					cacheHit = true
+				} else {
+					raw, rerr := ioutil.ReadFile(path)
+					if rerr != nil {
+						return fmt.Errorf("error reading file content while walking box: %s\n", rerr)
 					}
-				*/
-				fileData.Content = []byte("{%" + path + "%}")
+					digest := sha256.Sum256(raw)
+					fileData.Digest = hex.EncodeToString(digest[:])
+					fileData.OriginalSize = int64(len(raw))
+				}
+				newManifest[cacheKey] = cacheEntry{
+					Path:       cacheKey,
+					Size:       fileData.OriginalSize,
+					ModTime:    info.ModTime().Unix(),
+					SHA256:     fileData.Digest,
+					Identifier: fileData.Identifier,
+					Escaped:    cachedEscaped,
+				}
+
+				if cacheHit {
+					// already escaped by a previous run; splice it in as-is,
+					// even for an empty file where cachedEscaped is itself ""
+					fileData.Content = []byte(cachedEscaped)
+				} else {
+					fileData.Content = []byte("{%" + path + "%}")
+					pathToCacheKey[path] = cacheKey
+				}
 				box.Files = append(box.Files, fileData)
 
 				// add tree entry
@@ -133,7 +301,10 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 	// execute template to buffer
 	err := tmplEmbeddedBox.Execute(
 		embedSourceUnformated,
-		embedFileDataType{pkg.Name, boxes},
+		embedFileDataType{
+			Package: pkg.Name,
+			Boxes:   boxes,
+		},
 	)
 	if err != nil {
 		return fmt.Errorf("error writing embedded box to file (template execute): %s\n", err)
@@ -156,6 +327,11 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 	const bufSize = 100 * 1024
 	var buffer [bufSize]byte
 
+	// escapedByPath records the escaped Go string body produced for each
+	// file read below, so it can be written back into the cache manifest and
+	// reused verbatim on the next run instead of being recomputed.
+	escapedByPath := make(map[string]string)
+
 	_, err = ft.ExecuteFunc(bufWriter, func(w io.Writer, tag string) (int, error) {
 		fileName, err := strconv.Unquote("\"" + tag + "\"")
 		if err != nil {
@@ -166,6 +342,9 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 			return 0, err
 		}
 
+		var captured bytes.Buffer
+		w = io.MultiWriter(w, &captured)
+
 		n := 0
 
 		var processed = bufSize
@@ -265,15 +444,32 @@ func writeBoxesGo(pkg *build.Package, out io.Writer) error {
 
 		f.Close()
 
+		escapedByPath[fileName] = captured.String()
+
 		return int(n), err
 	})
 	if err != nil {
 		return fmt.Errorf("error writing embedSource to file: %s\n", err)
 	}
+
+	for path, cacheKey := range pathToCacheKey {
+		escaped, ok := escapedByPath[path]
+		if !ok {
+			continue
+		}
+		entry := newManifest[cacheKey]
+		entry.Escaped = escaped
+		newManifest[cacheKey] = entry
+	}
 	err = bufWriter.Flush()
 	if err != nil {
 		return fmt.Errorf("error writing embedSource to file: %s\n", err)
 	}
+
+	if err := saveCacheManifest(pkg.Dir, newManifest); err != nil {
+		return fmt.Errorf("error writing %s: %s\n", riceCacheFilename, err)
+	}
+
 	return nil
 }
 
@@ -286,7 +482,7 @@ func operationEmbedGo(pkg *build.Package) {
 	}
 	defer boxFile.Close()
 
-	err = writeBoxesGo(pkg, boxFile)
+	err = writeBoxesGo(pkg, boxFile, *excludeFlag, *includeFlag)
 	if err != nil {
 		log.Printf("error creating embedded box file: %s\n", err)
 		os.Exit(1)
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestShouldExclude(t *testing.T) {
+	newSet := func(patterns string) *ignoreSet {
+		s := newIgnoreSet()
+		if err := s.addPatterns(patterns); err != nil {
+			t.Fatalf("addPatterns(%q): %s", patterns, err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		exclude    string
+		include    string
+		filename   string
+		isDir      bool
+		wantExclud bool
+		wantSkip   bool
+	}{
+		{
+			name:     "root is never excluded",
+			include:  "*.go",
+			filename: "",
+		},
+		{
+			name:       "excluded directory is pruned",
+			exclude:    "vendor",
+			filename:   "vendor",
+			isDir:      true,
+			wantExclud: true,
+			wantSkip:   true,
+		},
+		{
+			name:     "include mismatch on a directory does not prune it",
+			include:  "*.go",
+			filename: "sub",
+			isDir:    true,
+		},
+		{
+			name:       "include mismatch on a file drops only that file",
+			include:    "*.go",
+			filename:   "sub/data.txt",
+			wantExclud: true,
+			wantSkip:   false,
+		},
+		{
+			name:     "include match on a nested file is kept",
+			include:  "*.go",
+			filename: "sub/a.go",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exclude, skip := shouldExclude(newSet(tt.exclude), newSet(tt.include), tt.include, tt.filename, tt.isDir)
+			if exclude != tt.wantExclud || skip != tt.wantSkip {
+				t.Errorf("shouldExclude(%q, isDir=%v) = (%v, %v), want (%v, %v)",
+					tt.filename, tt.isDir, exclude, skip, tt.wantExclud, tt.wantSkip)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const riceignoreFilename = ".riceignore"
+
+// ignoreRule is one compiled line of a .riceignore file or an -exclude/
+// -include flag value. It follows gitignore semantics: patterns without a
+// slash match the basename at any depth, patterns containing a slash are
+// anchored to the box root, a trailing slash restricts the rule to
+// directories, and a leading "!" negates (re-includes) a previous match.
+type ignoreRule struct {
+	raw      string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// ignoreSet is an ordered set of glob/gitignore-style patterns for a box.
+// writeBoxesGo builds one set from the -exclude flag combined with any
+// .riceignore file found at the box root, and a separate set from the
+// -include flag. Later rules override earlier ones, matching gitignore order.
+type ignoreSet struct {
+	rules []ignoreRule
+}
+
+func newIgnoreSet() *ignoreSet {
+	return &ignoreSet{}
+}
+
+// addPattern compiles and appends a single glob/gitignore-style pattern.
+func (s *ignoreSet) addPattern(pattern string) error {
+	pattern = strings.TrimRight(pattern, "\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	rule := ignoreRule{raw: pattern}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	rule.anchored = strings.HasPrefix(pattern, "/") || strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", rule.raw, err)
+	}
+	rule.re = re
+
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// addPatterns compiles a comma-separated list of patterns, as used by the
+// -exclude and -include flags.
+func (s *ignoreSet) addPatterns(csv string) error {
+	if csv == "" {
+		return nil
+	}
+	for _, pattern := range strings.Split(csv, ",") {
+		if err := s.addPattern(strings.TrimSpace(pattern)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRiceignore reads a .riceignore file at boxPath, if any, and appends its
+// rules to the set. It is not an error for the file to be absent.
+func (s *ignoreSet) loadRiceignore(boxPath string) error {
+	f, err := os.Open(filepath.Join(boxPath, riceignoreFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := s.addPattern(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// matches reports whether relPath (slash-separated, relative to the box
+// root) should be excluded. Rules are evaluated in order, so a later
+// negated rule can re-include a path an earlier rule excluded.
+func (s *ignoreSet) matches(relPath string, isDir bool) bool {
+	excluded := false
+	base := filepath.Base(relPath)
+	for _, rule := range s.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := relPath
+		if !rule.anchored {
+			target = base
+		}
+		if rule.re.MatchString(target) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// globToRegexp translates a doublestar-style glob (as used by gitignore and
+// the -exclude/-include flags) into an anchored regexp: "**" matches any
+// number of path segments, "*" matches within a single segment and "?"
+// matches a single rune within a segment.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			sb.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			sb.WriteString("[^/]")
+			i++
+		case strings.ContainsRune(`.+()|{}^$`, rune(pattern[i])):
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			i++
+		default:
+			sb.WriteByte(pattern[i])
+			i++
+		}
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestIgnoreSetMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{
+			name:     "unanchored basename matches at any depth",
+			patterns: []string{"*.log"},
+			path:     "sub/dir/debug.log",
+			want:     true,
+		},
+		{
+			name:     "leading slash anchors to the box root",
+			patterns: []string{"/main.go"},
+			path:     "vendor/pkg/main.go",
+			want:     false,
+		},
+		{
+			name:     "leading slash still matches at the root",
+			patterns: []string{"/main.go"},
+			path:     "main.go",
+			want:     true,
+		},
+		{
+			name:     "pattern containing a slash is anchored",
+			patterns: []string{"build/output"},
+			path:     "other/build/output",
+			want:     false,
+		},
+		{
+			name:     "trailing slash restricts the rule to directories",
+			patterns: []string{"build/"},
+			path:     "build",
+			isDir:    false,
+			want:     false,
+		},
+		{
+			name:     "trailing slash matches the directory itself",
+			patterns: []string{"build/"},
+			path:     "build",
+			isDir:    true,
+			want:     true,
+		},
+		{
+			name:     "later negated rule re-includes a path",
+			patterns: []string{"*.log", "!important.log"},
+			path:     "important.log",
+			want:     false,
+		},
+		{
+			name:     "doublestar matches any number of segments",
+			patterns: []string{"**/testdata/*"},
+			path:     "a/b/testdata/fixture.json",
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newIgnoreSet()
+			for _, p := range tt.patterns {
+				if err := s.addPattern(p); err != nil {
+					t.Fatalf("addPattern(%q): %s", p, err)
+				}
+			}
+			if got := s.matches(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("matches(%q, isDir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPatternsCSV(t *testing.T) {
+	s := newIgnoreSet()
+	if err := s.addPatterns("*.log, /main.go , !keep.log"); err != nil {
+		t.Fatalf("addPatterns: %s", err)
+	}
+	if len(s.rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(s.rules))
+	}
+	if s.rules[1].raw != "/main.go" {
+		t.Errorf("rule 1 raw = %q, want %q (whitespace should be trimmed)", s.rules[1].raw, "/main.go")
+	}
+}